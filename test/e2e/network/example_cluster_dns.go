@@ -17,17 +17,18 @@ limitations under the License.
 package network
 
 import (
+	"bytes"
+	_ "embed"
 	"fmt"
-	"io/ioutil"
-	"os"
-	"path/filepath"
-	"strings"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
 	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
 	api "k8s.io/kubernetes/pkg/apis/core"
 	"k8s.io/kubernetes/test/e2e/framework"
 	e2elog "k8s.io/kubernetes/test/e2e/framework/log"
@@ -40,13 +41,90 @@ const (
 	dnsReadyTimeout = time.Minute
 )
 
-const queryDNSPythonTemplate string = `
-import socket
-try:
-	socket.gethostbyname('%s')
-	print('ok')
-except:
-	print('err')`
+// Fixtures for the ClusterDns spec below, embedded at build time so the test
+// is hermetic and doesn't depend on the process's working directory to find
+// testdata/cluster-dns.
+var (
+	//go:embed testdata/cluster-dns/dns-backend-rc.yaml
+	dnsBackendRCYAML []byte
+	//go:embed testdata/cluster-dns/dns-backend-service.yaml
+	dnsBackendServiceYAML []byte
+	//go:embed testdata/cluster-dns/dns-frontend-pod.yaml
+	dnsFrontendPodYAML []byte
+)
+
+// backendSvcPortName is the name given to the dns-backend Service's port in
+// testdata/cluster-dns/dns-backend-service.yaml. Kubernetes DNS only
+// publishes a SRV record for a named Service port, so dnsQueryAgnhostCommand
+// needs it to build the SRV name it asks dns-query to resolve.
+const backendSvcPortName = "http"
+
+// dnsQueryAgnhostCommand execs the agnhost "dns-query" subcommand inside the
+// backend pod, which prints "ok" (and the resolved records) on success or
+// "err" on failure. The dns-backend fixture runs the agnhost test image (see
+// testdata/cluster-dns/dns-backend-rc.yaml), so /agnhost is guaranteed to be
+// present without relying on a Python interpreter being baked into whatever
+// image the backend happens to use. srvName, when non-empty, is also
+// resolved and gates the ok/err verdict, letting the test assert SRV
+// resolution and not just the A record.
+func dnsQueryAgnhostCommand(host, srvName string) []string {
+	cmd := []string{"/agnhost", "dns-query", host}
+	if srvName != "" {
+		cmd = append(cmd, "--srv-name", srvName)
+	}
+	return cmd
+}
+
+// backendSRVName returns the fully-qualified SRV name Kubernetes DNS
+// publishes for the dns-backend Service's named port in namespace ns.
+func backendSRVName(backendSvcName, ns string) string {
+	return fmt.Sprintf("_%s._tcp.%s.%s.svc.%s", backendSvcPortName, backendSvcName, ns, framework.TestContext.ClusterDNSDomain)
+}
+
+// dnsPolicyTestCase describes one row of the DNS policy matrix exercised by
+// the ClusterDns spec below. It pins down, for a given v1.DNSPolicy (plus an
+// optional explicit DNSConfig), whether a frontend pod using that policy is
+// expected to be able to resolve the backend service living in a different
+// namespace.
+type dnsPolicyTestCase struct {
+	name           string
+	dnsPolicy      v1.DNSPolicy
+	hostNetwork    bool
+	dnsConfig      func(clusterDNSIP string) *v1.PodDNSConfig
+	expectResolves bool
+}
+
+var dnsPolicyTestCases = []dnsPolicyTestCase{
+	{
+		name:           "ClusterFirst",
+		dnsPolicy:      v1.DNSClusterFirst,
+		expectResolves: true,
+	},
+	{
+		name:           "ClusterFirstWithHostNet",
+		dnsPolicy:      v1.DNSClusterFirstWithHostNet,
+		hostNetwork:    true,
+		expectResolves: true,
+	},
+	{
+		name:      "Default",
+		dnsPolicy: v1.DNSDefault,
+		// Default inherits the node's own /etc/resolv.conf, which does not
+		// know about cluster-local service names.
+		expectResolves: false,
+	},
+	{
+		name:      "None",
+		dnsPolicy: v1.DNSNone,
+		dnsConfig: func(clusterDNSIP string) *v1.PodDNSConfig {
+			return &v1.PodDNSConfig{
+				Nameservers: []string{clusterDNSIP},
+				Searches:    []string{fmt.Sprintf("svc.%s", framework.TestContext.ClusterDNSDomain)},
+			}
+		},
+		expectResolves: true,
+	},
+}
 
 var _ = SIGDescribe("ClusterDns [Feature:Example]", func() {
 	f := framework.NewDefaultFramework("cluster-dns")
@@ -56,119 +134,169 @@ var _ = SIGDescribe("ClusterDns [Feature:Example]", func() {
 		c = f.ClientSet
 	})
 
-	ginkgo.It("should create pod that uses dns", func() {
-		mkpath := func(file string) string {
-			return filepath.Join(os.Getenv("GOPATH"), "src/k8s.io/examples/staging/cluster-dns", file)
-		}
+	for _, tc := range dnsPolicyTestCases {
+		tc := tc
+		ginkgo.It(fmt.Sprintf("should create pod that uses dns with DNSPolicy %s", tc.name), func() {
+			runClusterDNSTest(f, c, tc)
+		})
+	}
+})
 
-		// contrary to the example, this test does not use contexts, for simplicity
-		// namespaces are passed directly.
-		// Also, for simplicity, we don't use yamls with namespaces, but we
-		// create testing namespaces instead.
-
-		backendRcYaml := mkpath("dns-backend-rc.yaml")
-		backendRcName := "dns-backend"
-		backendSvcYaml := mkpath("dns-backend-service.yaml")
-		backendSvcName := "dns-backend"
-		backendPodName := "dns-backend"
-		frontendPodYaml := mkpath("dns-frontend-pod.yaml")
-		frontendPodName := "dns-frontend"
-		frontendPodContainerName := "dns-frontend"
-
-		podOutput := "Hello World!"
-
-		// we need two namespaces anyway, so let's forget about
-		// the one created in BeforeEach and create two new ones.
-		namespaces := []*v1.Namespace{nil, nil}
-		for i := range namespaces {
-			var err error
-			namespaceName := fmt.Sprintf("dnsexample%d", i)
-			namespaces[i], err = f.CreateNamespace(namespaceName, nil)
-			framework.ExpectNoError(err, "failed to create namespace: %s", namespaceName)
-		}
+// runClusterDNSTest exercises the example cluster-dns backend/frontend
+// workload across two namespaces with the frontend pod configured according
+// to tc, and asserts that name resolution of the backend service succeeds or
+// fails as tc.expectResolves dictates.
+func runClusterDNSTest(f *framework.Framework, c clientset.Interface, tc dnsPolicyTestCase) {
+	backendRcName := "dns-backend"
+	backendSvcName := "dns-backend"
+	backendPodName := "dns-backend"
+	frontendPodName := "dns-frontend"
+	frontendPodContainerName := "dns-frontend"
 
-		for _, ns := range namespaces {
-			framework.RunKubectlOrDie("create", "-f", backendRcYaml, getNsCmdFlag(ns))
-		}
+	podOutput := "Hello World!"
 
-		for _, ns := range namespaces {
-			framework.RunKubectlOrDie("create", "-f", backendSvcYaml, getNsCmdFlag(ns))
-		}
+	// we need two namespaces anyway, so let's forget about
+	// the one created in BeforeEach and create two new ones.
+	namespaces := []*v1.Namespace{nil, nil}
+	for i := range namespaces {
+		var err error
+		namespaceName := fmt.Sprintf("dnsexample%d", i)
+		namespaces[i], err = f.CreateNamespace(namespaceName, nil)
+		framework.ExpectNoError(err, "failed to create namespace: %s", namespaceName)
+	}
 
-		// wait for objects
-		for _, ns := range namespaces {
-			e2epod.WaitForControlledPodsRunning(c, ns.Name, backendRcName, api.Kind("ReplicationController"))
-			framework.WaitForService(c, ns.Name, backendSvcName, true, framework.Poll, framework.ServiceStartTimeout)
-		}
-		// it is not enough that pods are running because they may be set to running, but
-		// the application itself may have not been initialized. Just query the application.
-		for _, ns := range namespaces {
-			label := labels.SelectorFromSet(labels.Set(map[string]string{"name": backendRcName}))
-			options := metav1.ListOptions{LabelSelector: label.String()}
-			pods, err := c.CoreV1().Pods(ns.Name).List(options)
-			framework.ExpectNoError(err, "failed to list pods in namespace: %s", ns.Name)
-			err = e2epod.PodsResponding(c, ns.Name, backendPodName, false, pods)
-			framework.ExpectNoError(err, "waiting for all pods to respond")
-			e2elog.Logf("found %d backend pods responding in namespace %s", len(pods.Items), ns.Name)
-
-			err = framework.ServiceResponding(c, ns.Name, backendSvcName)
-			framework.ExpectNoError(err, "waiting for the service to respond")
-		}
+	for _, ns := range namespaces {
+		rc := &v1.ReplicationController{}
+		loadClusterDNSFixture(rc, dnsBackendRCYAML)
+		_, err := c.CoreV1().ReplicationControllers(ns.Name).Create(rc)
+		framework.ExpectNoError(err, "failed to create the dns-backend replication controller in namespace: %s", ns.Name)
+	}
+
+	for _, ns := range namespaces {
+		svc := &v1.Service{}
+		loadClusterDNSFixture(svc, dnsBackendServiceYAML)
+		_, err := c.CoreV1().Services(ns.Name).Create(svc)
+		framework.ExpectNoError(err, "failed to create the dns-backend service in namespace: %s", ns.Name)
+	}
 
-		// Now another tricky part:
-		// It may happen that the service name is not yet in DNS.
-		// So if we start our pod, it will fail. We must make sure
-		// the name is already resolvable. So let's try to query DNS from
-		// the pod we have, until we find our service name.
-		// This complicated code may be removed if the pod itself retried after
-		// dns error or timeout.
-		// This code is probably unnecessary, but let's stay on the safe side.
-		label := labels.SelectorFromSet(labels.Set(map[string]string{"name": backendPodName}))
+	// wait for objects
+	for _, ns := range namespaces {
+		e2epod.WaitForControlledPodsRunning(c, ns.Name, backendRcName, api.Kind("ReplicationController"))
+		framework.WaitForService(c, ns.Name, backendSvcName, true, framework.Poll, framework.ServiceStartTimeout)
+	}
+	// it is not enough that pods are running because they may be set to running, but
+	// the application itself may have not been initialized. Just query the application.
+	for _, ns := range namespaces {
+		label := labels.SelectorFromSet(labels.Set(map[string]string{"name": backendRcName}))
 		options := metav1.ListOptions{LabelSelector: label.String()}
-		pods, err := c.CoreV1().Pods(namespaces[0].Name).List(options)
+		pods, err := c.CoreV1().Pods(ns.Name).List(options)
+		framework.ExpectNoError(err, "failed to list pods in namespace: %s", ns.Name)
+		err = e2epod.PodsResponding(c, ns.Name, backendPodName, false, pods)
+		framework.ExpectNoError(err, "waiting for all pods to respond")
+		e2elog.Logf("found %d backend pods responding in namespace %s", len(pods.Items), ns.Name)
 
-		if err != nil || pods == nil || len(pods.Items) == 0 {
-			framework.Failf("no running pods found")
-		}
-		podName := pods.Items[0].Name
+		err = framework.ServiceResponding(c, ns.Name, backendSvcName)
+		framework.ExpectNoError(err, "waiting for the service to respond")
+	}
 
-		queryDNS := fmt.Sprintf(queryDNSPythonTemplate, backendSvcName+"."+namespaces[0].Name)
-		_, err = framework.LookForStringInPodExec(namespaces[0].Name, podName, []string{"python", "-c", queryDNS}, "ok", dnsReadyTimeout)
-		framework.ExpectNoError(err, "waiting for output from pod exec")
+	// Now another tricky part:
+	// It may happen that the service name is not yet in DNS.
+	// So if we start our pod, it will fail. We must make sure
+	// the name is already resolvable. So let's try to query DNS from
+	// the pod we have, until we find our service name.
+	// This complicated code may be removed if the pod itself retried after
+	// dns error or timeout.
+	// This code is probably unnecessary, but let's stay on the safe side.
+	label := labels.SelectorFromSet(labels.Set(map[string]string{"name": backendPodName}))
+	options := metav1.ListOptions{LabelSelector: label.String()}
+	pods, err := c.CoreV1().Pods(namespaces[0].Name).List(options)
 
-		updatedPodYaml := prepareResourceWithReplacedString(frontendPodYaml, fmt.Sprintf("dns-backend.development.svc.%s", framework.TestContext.ClusterDNSDomain), fmt.Sprintf("dns-backend.%s.svc.%s", namespaces[0].Name, framework.TestContext.ClusterDNSDomain))
+	if err != nil || pods == nil || len(pods.Items) == 0 {
+		framework.Failf("no running pods found")
+	}
+	podName := pods.Items[0].Name
 
-		// create a pod in each namespace
-		for _, ns := range namespaces {
-			framework.NewKubectlCommand("create", "-f", "-", getNsCmdFlag(ns)).WithStdinData(updatedPodYaml).ExecOrDie()
-		}
+	probeCmd := dnsQueryAgnhostCommand(backendSvcName+"."+namespaces[0].Name, backendSRVName(backendSvcName, namespaces[0].Name))
+	_, err = framework.LookForStringInPodExec(namespaces[0].Name, podName, probeCmd, "ok", dnsReadyTimeout)
+	framework.ExpectNoError(err, "waiting for output from pod exec")
+	// probeCmd passes --srv-name, so "ok" above already requires the SRV
+	// lookup to succeed as well; also check that dns-query printed the
+	// resolved SRV records for the named port.
+	_, err = framework.LookForStringInPodExec(namespaces[0].Name, podName, probeCmd, "SRV: [", dnsReadyTimeout)
+	framework.ExpectNoError(err, "waiting for dns-backend SRV record to resolve")
 
-		// wait until the pods have been scheduler, i.e. are not Pending anymore. Remember
-		// that we cannot wait for the pods to be running because our pods terminate by themselves.
-		for _, ns := range namespaces {
-			err := e2epod.WaitForPodNotPending(c, ns.Name, frontendPodName)
-			framework.ExpectNoError(err)
-		}
+	frontendPod := &v1.Pod{}
+	loadClusterDNSFixture(frontendPod, dnsFrontendPodYAML)
+	setBackendServiceEnv(frontendPod, fmt.Sprintf("dns-backend.%s.svc.%s:8000", namespaces[0].Name, framework.TestContext.ClusterDNSDomain))
+	applyDNSPolicy(frontendPod, c, tc)
+
+	// create a pod in each namespace
+	for _, ns := range namespaces {
+		_, err := c.CoreV1().Pods(ns.Name).Create(frontendPod.DeepCopy())
+		framework.ExpectNoError(err, "failed to create the dns-frontend pod in namespace: %s", ns.Name)
+	}
+
+	// wait until the pods have been scheduler, i.e. are not Pending anymore. Remember
+	// that we cannot wait for the pods to be running because our pods terminate by themselves.
+	for _, ns := range namespaces {
+		err := e2epod.WaitForPodNotPending(c, ns.Name, frontendPodName)
+		framework.ExpectNoError(err)
+	}
 
+	if tc.expectResolves {
 		// wait for pods to print their result
 		for _, ns := range namespaces {
 			_, err := framework.LookForStringInLog(ns.Name, frontendPodName, frontendPodContainerName, podOutput, framework.PodStartTimeout)
 			framework.ExpectNoError(err, "pod %s failed to print result in logs", frontendPodName)
 		}
-	})
-})
+		return
+	}
+
+	// the policy under test is not expected to be able to resolve the
+	// cluster-local backend service, so the frontend is expected never to
+	// print its success output; the wait below fails the test if it does
+	// within framework.PodStartTimeout.
+	for _, ns := range namespaces {
+		_, err := framework.LookForStringInLog(ns.Name, frontendPodName, frontendPodContainerName, podOutput, framework.PodStartTimeout)
+		if err == nil {
+			framework.Failf("pod %s resolved the backend service under DNSPolicy %s, but resolution was expected to fail", frontendPodName, tc.name)
+		}
+	}
+}
 
-func getNsCmdFlag(ns *v1.Namespace) string {
-	return fmt.Sprintf("--namespace=%v", ns.Name)
+// loadClusterDNSFixture decodes an embedded cluster-dns fixture into obj and
+// runs the client-go scheme's defaulting on it. That scheme registers no
+// defaulting functions for core/v1 types, so the apiserver fills in defaults
+// when the object is created.
+func loadClusterDNSFixture(obj runtime.Object, data []byte) {
+	err := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), len(data)).Decode(obj)
+	framework.ExpectNoError(err, "failed to decode cluster-dns fixture")
+
+	scheme.Scheme.Default(obj)
+}
+
+// setBackendServiceEnv points the frontend pod's BACKEND_SERVICE env var at
+// the given namespace's dns-backend service, replacing the placeholder value
+// baked into the fixture.
+func setBackendServiceEnv(pod *v1.Pod, backendService string) {
+	env := pod.Spec.Containers[0].Env
+	for i := range env {
+		if env[i].Name == "BACKEND_SERVICE" {
+			env[i].Value = backendService
+			return
+		}
+	}
+	pod.Spec.Containers[0].Env = append(env, v1.EnvVar{Name: "BACKEND_SERVICE", Value: backendService})
 }
 
-// pass enough context with the 'old' parameter so that it replaces what your really intended.
-func prepareResourceWithReplacedString(inputFile, old, new string) string {
-	f, err := os.Open(inputFile)
-	framework.ExpectNoError(err, "failed to open file: %s", inputFile)
-	defer f.Close()
-	data, err := ioutil.ReadAll(f)
-	framework.ExpectNoError(err, "failed to read from file: %s", inputFile)
-	podYaml := strings.Replace(string(data), old, new, 1)
-	return podYaml
+// applyDNSPolicy sets the frontend pod's DNSPolicy (and DNSConfig,
+// HostNetwork where the test case requires them).
+func applyDNSPolicy(pod *v1.Pod, c clientset.Interface, tc dnsPolicyTestCase) {
+	pod.Spec.DNSPolicy = tc.dnsPolicy
+	pod.Spec.HostNetwork = tc.hostNetwork
+	if tc.dnsConfig != nil {
+		svc, err := c.CoreV1().Services("kube-system").Get("kube-dns", metav1.GetOptions{})
+		framework.ExpectNoError(err, "failed to look up the cluster DNS service")
+		pod.Spec.DNSConfig = tc.dnsConfig(svc.Spec.ClusterIP)
+	}
 }
@@ -0,0 +1,50 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command agnhost is a binary that bundles a number of small subcommands
+// useful for e2e testing, so that a single image can stand in for many of
+// the purpose-built images tests would otherwise depend on.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	dnsquery "k8s.io/kubernetes/test/images/agnhost/dns-query"
+)
+
+var rootCmd = &cobra.Command{
+	Use:   "agnhost",
+	Short: "Agnhost is a binary for testing purposes",
+}
+
+func init() {
+	// NOTE: this checkout only carries the dns-query subcommand; agnhost
+	// normally registers many more (netexec, pause, dns-suffix, ...) here.
+	// Register new subcommands with additional rootCmd.AddCommand calls
+	// rather than replacing this file wholesale, so existing registrations
+	// are never dropped.
+	rootCmd.AddCommand(dnsquery.CmdDNSQuery)
+}
+
+func main() {
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
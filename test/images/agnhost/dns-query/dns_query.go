@@ -0,0 +1,110 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package dnsquery provides the "dns-query" agnhost subcommand, used by e2e
+// tests to verify DNS resolution from inside a pod without depending on a
+// Python interpreter being present in the image.
+package dnsquery
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// CmdDNSQuery is used by agnhost Cobra.
+var CmdDNSQuery = &cobra.Command{
+	Use:   "dns-query",
+	Short: "Resolves the A/AAAA records for a host, and optionally a SRV name",
+	Long: `Looks up the A/AAAA records for the given host, retrying on failure up to
+--retries times with --retry-delay between attempts.
+
+Prints "ok" followed by the resolved records on success, or "err" if every
+attempt failed. If --srv-name is set to a fully-qualified SRV name (e.g.
+"_http._tcp.my-svc.my-ns.svc.cluster.local"), it is resolved alongside the
+host on every attempt and a failure to resolve it also counts as a failed
+attempt, so "ok" only prints once both lookups succeed. TXT records for
+host are reported on a best-effort basis and never affect the ok/err
+verdict: plain Kubernetes Services don't publish TXT records at all.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runDNSQuery,
+}
+
+var (
+	retries    int
+	retryDelay time.Duration
+	srvName    string
+)
+
+func init() {
+	CmdDNSQuery.Flags().IntVar(&retries, "retries", 5, "number of additional attempts to make after an initial failed lookup")
+	CmdDNSQuery.Flags().DurationVar(&retryDelay, "retry-delay", 1*time.Second, "how long to wait between retries")
+	CmdDNSQuery.Flags().StringVar(&srvName, "srv-name", "", "an optional fully-qualified SRV name to resolve alongside host; a failure to resolve it also counts as a failed attempt")
+}
+
+func runDNSQuery(cmd *cobra.Command, args []string) {
+	host := args[0]
+
+	var (
+		addrs []string
+		srvs  []*net.SRV
+		err   error
+	)
+
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(retryDelay)
+		}
+
+		addrs, err = net.LookupHost(host)
+		if err != nil {
+			continue
+		}
+		if srvName != "" {
+			if _, srvs, err = net.LookupSRV("", "", srvName); err != nil {
+				continue
+			}
+		}
+		break
+	}
+
+	if err != nil {
+		fmt.Println("err")
+		fmt.Fprintf(os.Stderr, "dns-query: failed to resolve %q after %d attempt(s): %v\n", host, retries+1, err)
+		os.Exit(1)
+	}
+
+	fmt.Println("ok")
+	fmt.Printf("A/AAAA: %v\n", addrs)
+	if srvName != "" {
+		fmt.Printf("SRV: %v\n", srvs)
+	}
+	printTXTRecords(host)
+}
+
+// printTXTRecords reports TXT records for host when the resolver happens to
+// have them, but plain Kubernetes Services never publish TXT records, so
+// their absence is expected and must never flip the ok/err verdict above.
+func printTXTRecords(host string) {
+	if txts, err := net.LookupTXT(host); err == nil {
+		fmt.Printf("TXT: %v\n", txts)
+	} else {
+		fmt.Printf("TXT: unavailable (%v)\n", err)
+	}
+}